@@ -3,35 +3,57 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/dop251/goja"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/heptiolabs/healthcheck"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	abiFile          string
-	topicName        string
-	endpoint         string
-	address          string
-	webhook          string
-	db               string
-	livenessEndpoint string
-	delay            int64
-	chunkSize        int64
-	startingBlock    int64
+	abiFile           string
+	topicName         string
+	endpoint          string
+	address           string
+	webhook           string
+	db                string
+	livenessEndpoint  string
+	configFile        string
+	outboxFile        string
+	webhookSecret     string
+	delay             int64
+	chunkSize         int64
+	startingBlock     int64
+	confirmations     int64
+	webhookMaxAttempt int64
+	webhookTimeout    int64
+	lagThreshold      int64
+	scriptFile        string
+	scriptTimeout     int64
 )
 
 func init() {
@@ -41,11 +63,19 @@ func init() {
 	flag.StringVar(&abiFile, "abi", "abi.json", "abi json file")
 	flag.StringVar(&webhook, "w", "", "webhook enpoint to send events to")
 	flag.StringVar(&livenessEndpoint, "live", ":9000", "liveness endpoint to bind on")
-	flag.StringVar(&db, "db", "block.txt", "database to store information of parsed blocks")
+	flag.StringVar(&db, "db", "whisper.db", "boltdb file storing the last processed block, delivered events and recent block hashes")
+	flag.StringVar(&configFile, "config", "", "yaml file listing multiple contracts/events/webhooks to watch, overrides -a/-t/-abi/-w")
+	flag.StringVar(&outboxFile, "outbox", "outbox.db", "boltdb file used to durably queue undelivered webhooks")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "shared secret used to HMAC-sign outgoing webhooks")
 	flag.Int64Var(&delay, "d", 10, "delay in seconds before each run")
 	flag.Int64Var(&chunkSize, "c", 100, "chunk of blocks to parse in one run")
 	flag.Int64Var(&startingBlock, "s", 0, "starting block")
-	flag.Parse()
+	flag.Int64Var(&confirmations, "confirmations", 0, "number of blocks to wait for before considering a log final")
+	flag.Int64Var(&webhookMaxAttempt, "webhook-max-attempts", 5, "max delivery attempts, with exponential backoff, each time a pending webhook is replayed from the outbox")
+	flag.Int64Var(&webhookTimeout, "webhook-timeout", 10, "timeout in seconds for a single webhook delivery attempt")
+	flag.Int64Var(&lagThreshold, "lag-threshold", 50, "blocks behind chain head allowed before readiness fails")
+	flag.StringVar(&scriptFile, "script", "", "javascript file defining handle(event) to transform/filter/reroute webhooks before delivery")
+	flag.Int64Var(&scriptTimeout, "script-timeout", 250, "timeout in milliseconds for a single handle(event) invocation")
 }
 
 type WebhookRequest struct {
@@ -55,34 +85,737 @@ type WebhookRequest struct {
 	Data   map[string]any `json:"data"`
 }
 
-func main() {
-	if topicName == "" {
-		log.Fatal("error: please specify a topic to parse")
+// logKey identifies a single log uniquely enough to recognize it again
+// across overlapping polling ranges, so a reorg can be told apart from
+// a log we have already emitted.
+type logKey struct {
+	blockHash common.Hash
+	txHash    common.Hash
+	index     uint
+}
+
+// WatchEntry describes one contract/event/webhook combination in a -config
+// file, letting a single process watch several contracts at once.
+type WatchEntry struct {
+	Address string   `yaml:"address"`
+	ABI     string   `yaml:"abi"`
+	Events  []string `yaml:"events"`
+	Webhook string   `yaml:"webhook"`
+}
+
+// WatchConfig is the top-level shape of a -config yaml file.
+type WatchConfig struct {
+	Watch []WatchEntry `yaml:"watch"`
+}
+
+// eventTarget is what a single matched (address, topic0) pair dispatches to.
+type eventTarget struct {
+	abi     abi.ABI
+	event   abi.Event
+	indexed []abi.Argument
+	webhook string
+}
+
+// dispatchTable maps a contract address and an event's topic0 to the
+// ABI/webhook that should handle it, so one FilterQuery can cover every
+// watched contract and event at once.
+type dispatchTable map[common.Address]map[common.Hash]eventTarget
+
+var outboxBucket = []byte("outbox")
+
+// outboxEntry is a webhook delivery that has not been acknowledged yet. It
+// is persisted before the first delivery attempt so a crash mid-retry does
+// not lose the event.
+type outboxEntry struct {
+	Webhook     string            `json:"webhook"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body"`
+	BlockNumber int64             `json:"block_number"`
+
+	// EventKey and Record, when set, are what replayOutbox records in the
+	// Store on a successful replay, so delivery-via-replay is tracked for
+	// idempotency and /events history exactly like an inline delivery.
+	EventKey string       `json:"event_key,omitempty"`
+	Record   *EventRecord `json:"record,omitempty"`
+}
+
+// Outbox is a durable queue of undelivered webhook calls backed by BoltDB.
+type Outbox struct {
+	db *bbolt.DB
+}
+
+// openOutbox opens (creating if necessary) the boltdb file at path.
+func openOutbox(path string) (*Outbox, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening outbox: %v", err)
 	}
-	if endpoint == "" {
-		log.Fatal("error: please specify enpoint to work with")
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing outbox: %v", err)
+	}
+
+	return &Outbox{db: db}, nil
+}
+
+// Put durably records an undelivered webhook call under key.
+func (o *Outbox) Put(key string, entry outboxEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding outbox entry: %v", err)
+	}
+
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(outboxBucket).Put([]byte(key), body)
+	})
+}
+
+// Delete removes a delivered webhook call from the outbox.
+func (o *Outbox) Delete(key string) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete([]byte(key))
+	})
+}
+
+// All returns every pending outbox entry, keyed the same way Put stored it.
+func (o *Outbox) All() (map[string]outboxEntry, error) {
+	entries := make(map[string]outboxEntry)
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			var entry outboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries[string(k)] = entry
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Close releases the underlying boltdb file.
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// replayOutbox attempts to redeliver every pending entry, removing it from
+// the outbox on success and leaving it for the next replay otherwise. A
+// successful replay records the entry's event in store exactly like an
+// inline delivery would, so store.HasEvent still guards against
+// double-delivery even when the first attempt was the one that failed.
+func replayOutbox(outbox *Outbox, store *Store, metrics *Metrics) {
+	entries, err := outbox.All()
+	if err != nil {
+		log.Printf("error reading outbox: %v", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	log.Printf("replaying %d pending webhook(s) from outbox", len(entries))
+	for key, entry := range entries {
+		if err := sendWebhook(entry.Webhook, entry.Headers, entry.Body, metrics); err != nil {
+			log.Printf("replay of %s failed, left in outbox: %v", key, err)
+			continue
+		}
+		if err := outbox.Delete(key); err != nil {
+			log.Printf("error removing delivered webhook %s from outbox: %v", key, err)
+		}
+		if entry.EventKey != "" && entry.Record != nil {
+			if err := store.RecordEvent(entry.EventKey, *entry.Record); err != nil {
+				log.Printf("error recording delivered event %s: %v", entry.EventKey, err)
+			}
+		}
 	}
-	if address == "" {
-		log.Fatal("error: please specify address")
+}
+
+var (
+	storeMetaBucket   = []byte("meta")
+	storeEventsBucket = []byte("events")
+	storeHashesBucket = []byte("hashes")
+)
+
+// reorgWindow is how many blocks behind the newest recorded one we keep
+// block hashes for, to detect a reorg that happened while the process was
+// down.
+const reorgWindow = 256
+
+// EventRecord is a delivered event as kept in the store, both to guard
+// against double-delivery on restart and to answer /events queries. Address
+// and Topic identify the watched (contract, event) pair it came from, so a
+// startup reorg rollback can look the right webhook back up in the
+// dispatch table.
+type EventRecord struct {
+	Event       string         `json:"event"`
+	TxHash      string         `json:"tx_hash"`
+	Index       int64          `json:"index"`
+	BlockNumber int64          `json:"block_number"`
+	Removed     bool           `json:"removed"`
+	Data        map[string]any `json:"data"`
+	Address     string         `json:"address"`
+	Topic       string         `json:"topic"`
+}
+
+// Store is the embedded KV store backing the watcher's persisted state:
+// the last processed block, a history of delivered events and a ring of
+// recent block hashes used to detect reorgs on startup.
+type Store struct {
+	db *bbolt.DB
+}
+
+// openStore opens (creating if necessary) the boltdb file at path.
+func openStore(path string) (*Store, error) {
+	bdb, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store: %v", err)
 	}
-	if webhook == "" {
-		log.Fatal("error: please specify a webhook endpoint")
+
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{storeMetaBucket, storeEventsBucket, storeHashesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, fmt.Errorf("error initializing store: %v", err)
 	}
 
-	body, err := os.ReadFile(db)
-	if err == nil && string(body) != "" {
-		i, err := strconv.ParseInt(string(body), 10, 64)
+	return &Store{db: bdb}, nil
+}
+
+// Close releases the underlying boltdb file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// GetBlock returns the last processed block, or 0 if none was recorded yet.
+func (s *Store) GetBlock() (int64, error) {
+	var block int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(storeMetaBucket).Get([]byte("block"))
+		if v == nil {
+			return nil
+		}
+		i, err := strconv.ParseInt(string(v), 10, 64)
 		if err != nil {
-			log.Print("db: error corruped last block in block file, used default one")
-		} else if i > 0 {
-			log.Printf("db: found %d block in block file, using it", i)
-			startingBlock = i
+			return fmt.Errorf("corrupted last block in store: %v", err)
 		}
+		block = i
+		return nil
+	})
+	return block, err
+}
+
+// SetBlock persists the last processed block.
+func (s *Store) SetBlock(block int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeMetaBucket).Put([]byte("block"), []byte(fmt.Sprintf("%d", block)))
+	})
+}
+
+// HasEvent reports whether an event under key has already been delivered.
+func (s *Store) HasEvent(key string) (bool, error) {
+	var found bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		found = tx.Bucket(storeEventsBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return found, err
+}
+
+// RecordEvent marks an event under key as delivered, keeping it for
+// idempotency checks and /events history.
+func (s *Store) RecordEvent(key string, rec EventRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error encoding event record: %v", err)
 	}
 
-	// Reading a first block to start from
-	if _, err := os.OpenFile(db, os.O_CREATE|os.O_RDWR, 0755); err != nil {
-		log.Fatalf("error opening/creating database: %v", err)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeEventsBucket).Put([]byte(key), body)
+	})
+}
+
+// EventsBetween returns every delivered event whose block number falls in
+// [from, to], for the /events HTTP handler.
+func (s *Store) EventsBetween(from, to int64) ([]EventRecord, error) {
+	var records []EventRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeEventsBucket).ForEach(func(k, v []byte) error {
+			var rec EventRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.BlockNumber < from || rec.BlockNumber > to {
+				return nil
+			}
+			records = append(records, rec)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// EventsFrom returns every delivered event record, keyed the same way
+// RecordEvent stored it, whose block number is >= from. Used on startup to
+// find records delivered from a fork a detected reorg has invalidated.
+func (s *Store) EventsFrom(from int64) (map[string]EventRecord, error) {
+	records := make(map[string]EventRecord)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeEventsBucket).ForEach(func(k, v []byte) error {
+			var rec EventRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.BlockNumber < from {
+				return nil
+			}
+			records[string(k)] = rec
+			return nil
+		})
+	})
+	return records, err
+}
+
+// DeleteEvent removes an event record, e.g. one delivered from a fork a
+// startup reorg check has since invalidated.
+func (s *Store) DeleteEvent(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeEventsBucket).Delete([]byte(key))
+	})
+}
+
+// PutHash records the chain's hash for block number, trimming anything
+// older than reorgWindow so the ring does not grow without bound.
+func (s *Store) PutHash(number int64, hash common.Hash) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(storeHashesBucket)
+		if err := bucket.Put([]byte(fmt.Sprintf("%020d", number)), hash.Bytes()); err != nil {
+			return err
+		}
+
+		cutoff := []byte(fmt.Sprintf("%020d", number-reorgWindow))
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecentHashes returns every block hash still within the reorg window,
+// keyed by block number.
+func (s *Store) RecentHashes() (map[int64]common.Hash, error) {
+	hashes := make(map[int64]common.Hash)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(storeHashesBucket).ForEach(func(k, v []byte) error {
+			number, err := strconv.ParseInt(string(k), 10, 64)
+			if err != nil {
+				return err
+			}
+			hashes[number] = common.BytesToHash(v)
+			return nil
+		})
+	})
+	return hashes, err
+}
+
+// rewindOnReorg compares every recent stored block hash against the live
+// chain and, on the first mismatch, invalidates events delivered from the
+// now-abandoned fork and returns that block number so the watcher re-scans
+// from the point the reorg diverged.
+func rewindOnReorg(client *ethclient.Client, store *Store, table dispatchTable, outbox *Outbox, metrics *Metrics, startingBlock int64) int64 {
+	hashes, err := store.RecentHashes()
+	if err != nil || len(hashes) == 0 {
+		return startingBlock
+	}
+
+	numbers := make([]int64, 0, len(hashes))
+	for number := range hashes {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	for _, number := range numbers {
+		header, err := client.HeaderByNumber(context.Background(), big.NewInt(number))
+		if err != nil {
+			log.Printf("error checking block %d for reorg on startup: %v", number, err)
+			continue
+		}
+		if header.Hash() != hashes[number] {
+			log.Printf("reorg detected on startup: block %d no longer matches chain, rewinding", number)
+			invalidateReorgedEvents(store, table, outbox, metrics, number)
+			return number
+		}
+	}
+
+	return startingBlock
+}
+
+// invalidateReorgedEvents purges state for blocks at or after divergedAt,
+// the block a startup reorg check diverged from, before replay resumes:
+//
+//   - Pending outbox entries (events whose inline delivery failed and were
+//     never recorded in store) are dropped outright. Left alone, they would
+//     sit unknown to this function forever and, once the webhook endpoint
+//     recovered, replayOutbox would deliver a webhook for a transaction
+//     that is no longer on the canonical chain, with zero rollback signal.
+//   - Delivered-event records are deleted, so a legitimate re-confirmation
+//     on the canonical chain isn't blocked by a stale record, and get a
+//     ".removed" webhook re-emitted for every one of them that was not
+//     already a rollback itself. A crash that lands between a live reorg
+//     and the next restart would otherwise lose that rollback signal
+//     entirely, since it lives only in the in-memory `seen` map.
+func invalidateReorgedEvents(store *Store, table dispatchTable, outbox *Outbox, metrics *Metrics, divergedAt int64) {
+	purgeReorgedOutbox(outbox, divergedAt)
+
+	records, err := store.EventsFrom(divergedAt)
+	if err != nil {
+		log.Printf("error reading events to invalidate after reorg: %v", err)
+		return
+	}
+
+	for key, rec := range records {
+		if err := store.DeleteEvent(key); err != nil {
+			log.Printf("error deleting reorged event %s: %v", key, err)
+		}
+		if rec.Removed {
+			continue
+		}
+
+		target, ok := table[common.HexToAddress(rec.Address)][common.HexToHash(rec.Topic)]
+		if !ok {
+			continue
+		}
+
+		body, err := json.Marshal(WebhookRequest{
+			Event:  rec.Event + ".removed",
+			TxHash: rec.TxHash,
+			Index:  rec.Index,
+			Data:   rec.Data,
+		})
+		if err != nil {
+			log.Printf("error encoding reorg rollback for tx %s: %v", rec.TxHash, err)
+			continue
+		}
+
+		outboxKey := fmt.Sprintf("reorg-%s", key)
+		if err := outbox.Put(outboxKey, outboxEntry{Webhook: target.webhook, Body: body, BlockNumber: rec.BlockNumber}); err != nil {
+			log.Printf("error enqueueing reorg rollback for tx %s: %v", rec.TxHash, err)
+			continue
+		}
+		if err := attemptWebhook(target.webhook, nil, body, metrics); err != nil {
+			log.Printf("reorg rollback delivery for tx %s failed, left in outbox for retry: %v", rec.TxHash, err)
+			continue
+		}
+		if err := outbox.Delete(outboxKey); err != nil {
+			log.Printf("error removing delivered reorg rollback %s from outbox: %v", outboxKey, err)
+		}
+	}
+}
+
+// purgeReorgedOutbox drops every pending outbox entry whose block is at or
+// after divergedAt, before it can be replayed as a webhook for a
+// transaction that turned out not to be on the canonical chain. These are
+// events whose inline delivery failed and that handleLog never got to
+// record in store, so invalidateReorgedEvents' scan of delivered records
+// would never see them.
+func purgeReorgedOutbox(outbox *Outbox, divergedAt int64) {
+	entries, err := outbox.All()
+	if err != nil {
+		log.Printf("error reading outbox to purge after reorg: %v", err)
+		return
+	}
+
+	for key, entry := range entries {
+		if entry.BlockNumber < divergedAt {
+			continue
+		}
+		if err := outbox.Delete(key); err != nil {
+			log.Printf("error purging reorged outbox entry %s: %v", key, err)
+		}
+	}
+}
+
+// eventsHandler serves GET /events?from=&to=, returning delivered events
+// whose block number falls in the given (inclusive) range.
+func eventsHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, err := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		if err != nil {
+			to = math.MaxInt64
+		}
+
+		records, err := store.EventsBetween(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			log.Printf("error encoding /events response: %v", err)
+		}
+	}
+}
+
+// currentBlockGauge and headGauge track the watcher's progress and the
+// chain's head atomically so the readiness check can compute chain lag
+// without an extra RPC call of its own.
+var (
+	currentBlockGauge int64
+	headGauge         int64
+	lastPollUnix      int64
+)
+
+// touchLastPoll records that we just completed a successful round of work
+// (a FilterLogs call, or a log delivered over a subscription), so the
+// liveness check can tell a stalled watcher from an idle one.
+func touchLastPoll() {
+	atomic.StoreInt64(&lastPollUnix, time.Now().Unix())
+}
+
+// Metrics holds the Prometheus collectors threaded through the main loop
+// and handleLog, exposed on /metrics.
+type Metrics struct {
+	LogsProcessed   prometheus.Counter
+	WebhookAttempts *prometheus.CounterVec
+	WebhookLatency  prometheus.Histogram
+	FilterLatency   prometheus.Histogram
+	ChainLag        prometheus.Gauge
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		LogsProcessed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "whisper_logs_processed_total",
+			Help: "Total number of logs matched against a watched event.",
+		}),
+		WebhookAttempts: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "whisper_webhook_attempts_total",
+			Help: "Webhook delivery attempts, labeled by outcome (status code or \"error\").",
+		}, []string{"status"}),
+		WebhookLatency: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "whisper_webhook_duration_seconds",
+			Help:    "Latency of webhook delivery attempts.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		FilterLatency: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "whisper_filter_logs_duration_seconds",
+			Help:    "Latency of FilterLogs calls.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		ChainLag: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "whisper_chain_lag_blocks",
+			Help: "Difference between the chain head and the last processed block.",
+		}),
+	}
+}
+
+// monitorChainLag periodically refreshes headGauge and the chain-lag gauge,
+// independent of whether logs are arriving via polling or a subscription.
+func monitorChainLag(client *ethclient.Client, metrics *Metrics) {
+	ticker := time.NewTicker(time.Second * time.Duration(delay))
+	for range ticker.C {
+		header, err := client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			log.Printf("error fetching head for lag metric: %v", err)
+			continue
+		}
+
+		atomic.StoreInt64(&headGauge, header.Number.Int64())
+		lag := header.Number.Int64() - atomic.LoadInt64(&currentBlockGauge)
+		metrics.ChainLag.Set(float64(lag))
+	}
+}
+
+// replayLoop retries pending outbox entries on its own ticker, independent
+// of the ingest loop (the polling ticker or the subscription's logs
+// channel). sendWebhook blocks for up to webhookMaxAttempt tries with
+// exponential backoff per entry, so running it inline in the ingest
+// select loop would let a slow or down webhook endpoint stall new
+// FilterLogs calls or subscribed log delivery behind it.
+func replayLoop(outbox *Outbox, store *Store, metrics *Metrics) {
+	ticker := time.NewTicker(time.Second * time.Duration(delay))
+	defer ticker.Stop()
+	for range ticker.C {
+		replayOutbox(outbox, store, metrics)
+	}
+}
+
+// scriptEvent is what a -script's handle(event) function receives.
+type scriptEvent struct {
+	Event       string         `json:"event"`
+	TxHash      string         `json:"tx_hash"`
+	Index       int64          `json:"index"`
+	BlockNumber int64          `json:"block_number"`
+	Data        map[string]any `json:"data"`
+}
+
+// scriptOverride is what handle(event) may return to reroute delivery to a
+// different destination instead of just reshaping the payload.
+type scriptOverride struct {
+	URL     string
+	Headers map[string]string
+	Body    any
+}
+
+// Transformer runs a user-supplied JS handle(event) function against every
+// outgoing event, letting scripts drop, reshape or reroute it. The VM is
+// created once and reused; a per-call timeout guards against a bad script
+// stalling the ticker loop.
+type Transformer struct {
+	vm      *goja.Runtime
+	handle  goja.Callable
+	timeout time.Duration
+}
+
+// newTransformer loads path, evaluates it and resolves its handle(event)
+// function.
+func newTransformer(path string, timeout time.Duration) (*Transformer, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading script: %v", err)
+	}
+
+	vm := goja.New()
+	// scriptEvent is tagged with the snake_case names the request documents
+	// (event, tx_hash, ...); without this mapper goja would expose the Go
+	// field names (Event, TxHash, ...) to JS instead.
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+	vm.Set("bigint", func(s string) *big.Int {
+		n := new(big.Int)
+		if _, ok := n.SetString(s, 0); !ok {
+			panic(vm.ToValue(fmt.Sprintf("bigint: invalid number %q", s)))
+		}
+		return n
+	})
+	vm.Set("hex", func(v goja.Value) string {
+		switch x := v.Export().(type) {
+		case *big.Int:
+			return "0x" + x.Text(16)
+		case string:
+			return "0x" + hex.EncodeToString([]byte(x))
+		default:
+			return fmt.Sprintf("0x%x", x)
+		}
+	})
+	vm.Set("log", func(args ...any) {
+		log.Println(append([]any{"script:"}, args...)...)
+	})
+
+	if _, err := vm.RunString(string(body)); err != nil {
+		return nil, fmt.Errorf("error evaluating script: %v", err)
+	}
+
+	handle, ok := goja.AssertFunction(vm.Get("handle"))
+	if !ok {
+		return nil, fmt.Errorf("script does not define a handle(event) function")
+	}
+
+	return &Transformer{vm: vm, handle: handle, timeout: timeout}, nil
+}
+
+// Transform invokes handle(event) against evt. It returns skip=true if the
+// script wants the event dropped, a non-nil override if the script
+// returned {url, headers, body}, or the (possibly reshaped) payload to send
+// to the event's original webhook otherwise.
+func (t *Transformer) Transform(evt scriptEvent) (skip bool, override *scriptOverride, payload any, err error) {
+	timer := time.AfterFunc(t.timeout, func() {
+		t.vm.Interrupt("handle() timed out")
+	})
+	defer timer.Stop()
+
+	result, err := t.handle(goja.Undefined(), t.vm.ToValue(evt))
+	if err != nil {
+		return false, nil, nil, fmt.Errorf("error running handle(event): %v", err)
+	}
+
+	if result == nil || goja.IsNull(result) || goja.IsUndefined(result) || !result.ToBoolean() {
+		return true, nil, nil, nil
+	}
+
+	exported, ok := result.Export().(map[string]any)
+	if !ok {
+		return false, nil, result.Export(), nil
+	}
+
+	rawURL, hasURL := exported["url"]
+	if !hasURL {
+		return false, nil, exported, nil
+	}
+
+	urlStr, _ := rawURL.(string)
+	override = &scriptOverride{URL: urlStr, Body: exported["body"]}
+	if rawHeaders, ok := exported["headers"].(map[string]any); ok {
+		override.Headers = make(map[string]string, len(rawHeaders))
+		for k, v := range rawHeaders {
+			override.Headers[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return false, override, nil, nil
+}
+
+func main() {
+	flag.Parse()
+
+	var config WatchConfig
+	if configFile != "" {
+		c, err := loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("error loading config: %v", err)
+		}
+		config = c
+	} else {
+		if topicName == "" {
+			log.Fatal("error: please specify a topic to parse")
+		}
+		if address == "" {
+			log.Fatal("error: please specify address")
+		}
+		if webhook == "" {
+			log.Fatal("error: please specify a webhook endpoint")
+		}
+		config = WatchConfig{Watch: []WatchEntry{
+			{Address: address, ABI: abiFile, Events: []string{topicName}, Webhook: webhook},
+		}}
+	}
+
+	if endpoint == "" {
+		log.Fatal("error: please specify enpoint to work with")
+	}
+
+	// runSubscription delivers logs as eth_subscribe reports them and has no
+	// notion of a confirmation window, so combining it with -confirmations
+	// would silently ignore the flag. Require the caller to pick one.
+	if isWebsocketEndpoint(endpoint) && confirmations > 0 {
+		log.Fatal("error: -confirmations is not supported over a websocket endpoint, use an http(s) endpoint for confirmation-gated delivery")
+	}
+
+	store, err := openStore(db)
+	if err != nil {
+		log.Fatalf("error opening store: %v", err)
+	}
+	defer store.Close()
+
+	if block, err := store.GetBlock(); err != nil {
+		log.Printf("store: %v, using default starting block", err)
+	} else if block > 0 {
+		log.Printf("store: found %d as last processed block, using it", block)
+		startingBlock = block
 	}
 
 	client, err := ethclient.Dial(endpoint)
@@ -91,40 +824,83 @@ func main() {
 	}
 	defer client.Close()
 
-	f, err := os.Open(abiFile)
+	table, addresses, topics, err := buildDispatchTable(config)
 	if err != nil {
-		log.Fatalf("error opening abi file: %v", err)
+		log.Fatalf("error building watch list: %v", err)
 	}
-	contractAbi, err := abi.JSON(f)
+
+	metrics := newMetrics()
+
+	outbox, err := openOutbox(outboxFile)
 	if err != nil {
-		log.Fatalf("error reading abi: %v", err)
+		log.Fatalf("error opening outbox: %v", err)
 	}
+	defer outbox.Close()
 
-	// Finding out a particular event
-	var mappedEvent abi.Event
-	indexed := make([]abi.Argument, 0)
-	for _, event := range contractAbi.Events {
-		if event.Name != topicName {
-			continue
-		}
+	// rewindOnReorg needs the dispatch table and outbox to purge/roll back
+	// state from a fork it finds invalidated, so it must run after both are
+	// built, and it must run before the first replayOutbox: otherwise an
+	// event orphaned by the reorg could be replayed before we get a chance
+	// to purge it from the outbox.
+	startingBlock = rewindOnReorg(client, store, table, outbox, metrics, startingBlock)
+	replayOutbox(outbox, store, metrics)
 
-		for _, input := range event.Inputs {
-			if input.Indexed {
-				indexed = append(indexed, input)
-			}
+	var transformer *Transformer
+	if scriptFile != "" {
+		transformer, err = newTransformer(scriptFile, time.Duration(scriptTimeout)*time.Millisecond)
+		if err != nil {
+			log.Fatalf("error loading script: %v", err)
 		}
-
-		mappedEvent = event
+		log.Printf("transforming events with %s", scriptFile)
 	}
 
-	log.Printf("starting to work with contract %s and event %s at block %d", address, topicName, startingBlock)
+	log.Printf("starting to work with %d contract(s) at block %d", len(addresses), startingBlock)
 	ticker := time.NewTicker(time.Second * time.Duration(delay))
 	currentBlock := startingBlock
+	atomic.StoreInt64(&currentBlockGauge, currentBlock)
+	touchLastPoll()
+
+	go monitorChainLag(client, metrics)
+	go replayLoop(outbox, store, metrics)
 
-	// Starting readiness probe
 	health := healthcheck.NewHandler()
-	go http.ListenAndServe(livenessEndpoint, health)
-	log.Printf("start listening for liveness checks on %s", livenessEndpoint)
+	health.AddLivenessCheck("log-ingestion-freshness", func() error {
+		age := time.Since(time.Unix(atomic.LoadInt64(&lastPollUnix), 0))
+		maxAge := time.Duration(3*delay) * time.Second
+		if age > maxAge {
+			return fmt.Errorf("no logs processed in %s, exceeds %s", age, maxAge)
+		}
+		return nil
+	})
+	health.AddReadinessCheck("chain-lag", func() error {
+		lag := atomic.LoadInt64(&headGauge) - atomic.LoadInt64(&currentBlockGauge)
+		if lag > lagThreshold {
+			return fmt.Errorf("chain lag of %d blocks exceeds threshold %d", lag, lagThreshold)
+		}
+		return nil
+	})
+
+	// Starting readiness/liveness probes, /metrics and the /events indexer
+	// endpoint on the same listener.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", eventsHandler(store))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", health)
+	go http.ListenAndServe(livenessEndpoint, mux)
+	log.Printf("start listening for liveness checks, /metrics and /events on %s", livenessEndpoint)
+
+	if isWebsocketEndpoint(endpoint) {
+		runSubscription(client, table, addresses, topics, outbox, store, metrics, transformer)
+		return
+	}
+
+	// seen tracks logs we have already emitted within the reorg window,
+	// keyed by (block hash, tx hash, log index), so overlapping polling
+	// ranges can tell a genuine reorg (a previously seen log whose block
+	// falls inside the rescanned range but that FilterLogs no longer
+	// returns, or that comes back with Removed set) from a log we already
+	// forwarded.
+	seen := make(map[logKey]types.Log)
 
 	for {
 		select {
@@ -134,86 +910,483 @@ func main() {
 				log.Printf("error getting header: %v", err)
 				break
 			}
-			lastBlock := header.Number.Int64()
+
+			safeHead := header.Number.Int64() - confirmations
+			if safeHead < currentBlock {
+				log.Printf("waiting for %d confirmations, head at %d", confirmations, header.Number.Int64())
+				break
+			}
+
 			toBlock := currentBlock + chunkSize
-			if lastBlock < toBlock {
-				toBlock = lastBlock
+			if safeHead < toBlock {
+				toBlock = safeHead
+			}
+
+			// Re-scan the confirmation window behind currentBlock on every
+			// tick so a reorg that happened after we emitted a log is caught
+			// even though its block was already processed.
+			fromBlock := currentBlock - confirmations
+			if fromBlock < startingBlock {
+				fromBlock = startingBlock
 			}
 
-			log.Printf("parsing events from %d to %d", currentBlock, currentBlock+chunkSize)
+			tailHeader, err := client.HeaderByNumber(context.Background(), big.NewInt(toBlock))
+			if err != nil {
+				log.Printf("error getting header for block %d: %v", toBlock, err)
+				break
+			}
+
+			log.Printf("parsing events from %d to %d", fromBlock, toBlock)
 			query := ethereum.FilterQuery{
-				FromBlock: big.NewInt(currentBlock),
+				FromBlock: big.NewInt(fromBlock),
 				ToBlock:   big.NewInt(toBlock),
-				Addresses: []common.Address{
-					common.HexToAddress(address),
-				},
+				Addresses: addresses,
+				Topics:    [][]common.Hash{topics},
 			}
 
+			filterStart := time.Now()
 			logs, err := client.FilterLogs(context.Background(), query)
+			metrics.FilterLatency.Observe(time.Since(filterStart).Seconds())
 			if err != nil {
 				log.Printf("error filtering logs: %v", err)
 				break
 			}
+			touchLastPoll()
+
+			// chunkOK tracks whether every log in this chunk was either
+			// acknowledged or durably enqueued in the outbox; the cursor
+			// must not advance past a chunk that failed partway through,
+			// or the failed log is lost for good.
+			chunkOK := true
+			returned := make(map[logKey]bool, len(logs))
+
+			for _, l := range logs {
+				key := logKey{blockHash: l.BlockHash, txHash: l.TxHash, index: l.Index}
+				returned[key] = true
+
+				if l.Removed {
+					if err := handleLog(l, table, outbox, store, metrics, transformer); err != nil {
+						log.Printf("error handling log: %v", err)
+						chunkOK = false
+					}
+					delete(seen, key)
+					continue
+				}
+
+				if _, ok := seen[key]; ok {
+					continue
+				}
 
-			if err := parseLogs(logs, mappedEvent, indexed, contractAbi); err != nil {
-				log.Printf("error parsing logs: %v", err)
+				if err := handleLog(l, table, outbox, store, metrics, transformer); err != nil {
+					log.Printf("error handling log: %v", err)
+					chunkOK = false
+					continue
+				}
+				seen[key] = l
+			}
+
+			// A log we saw on an earlier tick whose block falls inside the
+			// range we just rescanned, but that FilterLogs no longer
+			// returns, was reorged out from under us. eth_getLogs doesn't
+			// set Removed for this case, so the absence itself is the only
+			// signal we get; synthesize the .removed webhook before we
+			// forget about it.
+			for key, l := range seen {
+				if int64(l.BlockNumber) < fromBlock || returned[key] {
+					continue
+				}
+				reorged := l
+				reorged.Removed = true
+				if err := handleLog(reorged, table, outbox, store, metrics, transformer); err != nil {
+					log.Printf("error handling reorged-out log: %v", err)
+					chunkOK = false
+					continue
+				}
+				delete(seen, key)
+			}
+
+			for key, l := range seen {
+				if int64(l.BlockNumber) < fromBlock {
+					delete(seen, key)
+				}
+			}
+
+			if !chunkOK {
+				log.Printf("chunk %d-%d had a failed event, retrying before advancing cursor", fromBlock, toBlock)
 				break
 			}
 
-			if err := os.WriteFile(db, []byte(fmt.Sprintf("%d", currentBlock+chunkSize)), 0755); err != nil {
-				log.Fatalf("error writing last block to database: %v", err)
+			if err := store.SetBlock(toBlock); err != nil {
+				log.Fatalf("error writing last block to store: %v", err)
+			}
+			if err := store.PutHash(toBlock, tailHeader.Hash()); err != nil {
+				log.Printf("error persisting block hash: %v", err)
 			}
 
-			currentBlock += chunkSize
+			currentBlock = toBlock
+			atomic.StoreInt64(&currentBlockGauge, currentBlock)
 		}
 	}
 }
 
-func parseLogs(logs []types.Log, mappedEvent abi.Event, indexed []abi.Argument, contractAbi abi.ABI) error {
-	httpClient := &http.Client{
-		Timeout: time.Second * 30,
+// loadConfig reads and parses a -config yaml file describing the
+// contracts/events/webhooks to watch.
+func loadConfig(path string) (WatchConfig, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return WatchConfig{}, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var config WatchConfig
+	if err := yaml.Unmarshal(body, &config); err != nil {
+		return WatchConfig{}, fmt.Errorf("error parsing config: %v", err)
 	}
+	if len(config.Watch) == 0 {
+		return WatchConfig{}, fmt.Errorf("config has no entries under 'watch'")
+	}
+
+	return config, nil
+}
+
+// buildDispatchTable loads each entry's ABI, resolves its named events and
+// returns a combined lookup table plus the union of addresses and topic0
+// hashes needed to build a single FilterQuery covering every entry.
+func buildDispatchTable(config WatchConfig) (dispatchTable, []common.Address, []common.Hash, error) {
+	table := make(dispatchTable)
+	seenAddress := make(map[common.Address]bool)
+	seenTopic := make(map[common.Hash]bool)
+
+	var addresses []common.Address
+	var topics []common.Hash
+
+	for _, entry := range config.Watch {
+		if entry.Webhook == "" {
+			return nil, nil, nil, fmt.Errorf("entry for %s is missing a webhook", entry.Address)
+		}
+
+		f, err := os.Open(entry.ABI)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error opening abi file %s: %v", entry.ABI, err)
+		}
+		contractAbi, err := abi.JSON(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error reading abi %s: %v", entry.ABI, err)
+		}
+
+		addr := common.HexToAddress(entry.Address)
+		if table[addr] == nil {
+			table[addr] = make(map[common.Hash]eventTarget)
+		}
+		if !seenAddress[addr] {
+			seenAddress[addr] = true
+			addresses = append(addresses, addr)
+		}
+
+		for _, name := range entry.Events {
+			var mappedEvent abi.Event
+			indexed := make([]abi.Argument, 0)
+			found := false
+			for _, event := range contractAbi.Events {
+				if event.Name != name {
+					continue
+				}
+
+				for _, input := range event.Inputs {
+					if input.Indexed {
+						indexed = append(indexed, input)
+					}
+				}
 
-	for _, l := range logs {
-		if l.Topics[0] == mappedEvent.ID {
-			currentEvent := map[string]any{}
-			if err := abi.ParseTopicsIntoMap(currentEvent, indexed, l.Topics[1:]); err != nil {
-				return fmt.Errorf("error parsing indexed topics: %v", err)
+				mappedEvent = event
+				found = true
 			}
-			if err := contractAbi.UnpackIntoMap(currentEvent, mappedEvent.Name, l.Data); err != nil {
-				return fmt.Errorf("error parsing data: %v", err)
+			if !found {
+				return nil, nil, nil, fmt.Errorf("event %s not found in abi %s", name, entry.ABI)
 			}
 
-			webhookEvent := WebhookRequest{
-				TxHash: l.TxHash.Hex(),
-				Index:  int64(l.Index),
-				Event:  mappedEvent.Name,
-				Data:   currentEvent,
+			table[addr][mappedEvent.ID] = eventTarget{
+				abi:     contractAbi,
+				event:   mappedEvent,
+				indexed: indexed,
+				webhook: entry.Webhook,
 			}
-
-			body, err := json.Marshal(webhookEvent)
-			if err != nil {
-				return fmt.Errorf("error encoding event: %v", err)
+			if !seenTopic[mappedEvent.ID] {
+				seenTopic[mappedEvent.ID] = true
+				topics = append(topics, mappedEvent.ID)
 			}
+		}
+	}
 
-			req, err := http.NewRequest("POST", webhook, bytes.NewBuffer(body))
-			if err != nil {
-				return fmt.Errorf("error creating request: %v", err)
-			}
-			defer req.Body.Close()
+	return table, addresses, topics, nil
+}
 
-			res, err := httpClient.Do(req)
-			if err != nil {
-				return fmt.Errorf("error sending request: %v", err)
-			}
+// isWebsocketEndpoint reports whether endpoint points at a ws://or wss://
+// JSON-RPC server, in which case we can subscribe to logs instead of polling.
+func isWebsocketEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "ws://") || strings.HasPrefix(endpoint, "wss://")
+}
+
+// runSubscription watches for new matching logs via eth_subscribe, feeding
+// each one through handleLog as soon as it is mined. It resubscribes
+// automatically if the subscription errors out or its channel is closed.
+func runSubscription(client *ethclient.Client, table dispatchTable, addresses []common.Address, topics []common.Hash, outbox *Outbox, store *Store, metrics *Metrics, transformer *Transformer) {
+	query := ethereum.FilterQuery{
+		Addresses: addresses,
+		Topics:    [][]common.Hash{topics},
+	}
+
+	// idleTicker keeps liveness and the reorg-hash ring fresh on the same
+	// cadence as the polling loop even while no matching log arrives; outbox
+	// replay runs independently via replayLoop, not off this ticker.
+	idleTicker := time.NewTicker(time.Second * time.Duration(delay))
+	defer idleTicker.Stop()
+
+	for {
+		logs := make(chan types.Log)
+		sub, err := client.SubscribeFilterLogs(context.Background(), query, logs)
+		if err != nil {
+			log.Printf("error subscribing to logs: %v, retrying in %ds", err, delay)
+			time.Sleep(time.Second * time.Duration(delay))
+			continue
+		}
+
+		log.Printf("subscribed to logs for %d contract(s)", len(addresses))
+		touchLastPoll()
+
+	subscription:
+		for {
+			select {
+			case <-idleTicker.C:
+				// A quiet subscription is still alive, just idle; touch here
+				// too so liveness reflects that, not just "a log arrived".
+				touchLastPoll()
 
-			if res.StatusCode > 299 || res.StatusCode < 200 {
-				return fmt.Errorf("error in response code %d", res.StatusCode)
+				// A contract with no matching events never hits the PutHash
+				// call below, so RecentHashes would come back sparse or
+				// empty and rewindOnReorg would have nothing to compare
+				// against. Record the head hash periodically regardless of
+				// whether any log matched, and advance currentBlockGauge
+				// from it too, or chain-lag would report this subscription
+				// unhealthy for staying quiet rather than falling behind.
+				if header, err := client.HeaderByNumber(context.Background(), nil); err != nil {
+					log.Printf("error fetching head for reorg-hash tracking: %v", err)
+				} else if err := store.PutHash(header.Number.Int64(), header.Hash()); err != nil {
+					log.Printf("error persisting block hash: %v", err)
+				} else {
+					atomic.StoreInt64(&currentBlockGauge, header.Number.Int64())
+				}
+			case err := <-sub.Err():
+				log.Printf("subscription error: %v, resubscribing", err)
+				break subscription
+			case l := <-logs:
+				touchLastPoll()
+
+				if err := handleLog(l, table, outbox, store, metrics, transformer); err != nil {
+					log.Printf("error handling log: %v", err)
+					continue
+				}
+
+				if err := store.SetBlock(int64(l.BlockNumber)); err != nil {
+					log.Printf("error persisting block: %v", err)
+				}
+				if err := store.PutHash(int64(l.BlockNumber), l.BlockHash); err != nil {
+					log.Printf("error persisting block hash: %v", err)
+				}
+				atomic.StoreInt64(&currentBlockGauge, int64(l.BlockNumber))
 			}
+		}
+
+		sub.Unsubscribe()
+	}
+}
+
+// handleLog looks up the (address, topic0) pair of l in table, decodes it
+// with the matching ABI/event and durably delivers it to that entry's
+// webhook via the outbox. It is shared by both the polling ticker loop and
+// the subscription channel. store.HasEvent guards against double-delivery
+// if a restart re-processes a chunk that was already fully handled.
+func handleLog(l types.Log, table dispatchTable, outbox *Outbox, store *Store, metrics *Metrics, transformer *Transformer) error {
+	events, ok := table[l.Address]
+	if !ok {
+		return nil
+	}
+	target, ok := events[l.Topics[0]]
+	if !ok {
+		return nil
+	}
 
-			log.Printf("found event at tx %s, with params: %v", webhookEvent.TxHash, webhookEvent)
+	metrics.LogsProcessed.Inc()
+
+	// Keying on the block hash too (not just tx_hash-index) means a log
+	// re-confirmed on the canonical chain after being rolled back by a
+	// reorg gets a fresh key, instead of being silently swallowed by the
+	// stale "removed" record left under the old fork's key.
+	eventKey := fmt.Sprintf("%s-%s-%d", l.BlockHash.Hex(), l.TxHash.Hex(), l.Index)
+	if !l.Removed {
+		delivered, err := store.HasEvent(eventKey)
+		if err != nil {
+			return fmt.Errorf("error checking delivered events: %v", err)
+		}
+		if delivered {
+			return nil
 		}
 	}
 
+	currentEvent := map[string]any{}
+	if err := abi.ParseTopicsIntoMap(currentEvent, target.indexed, l.Topics[1:]); err != nil {
+		return fmt.Errorf("error parsing indexed topics: %v", err)
+	}
+	if err := target.abi.UnpackIntoMap(currentEvent, target.event.Name, l.Data); err != nil {
+		return fmt.Errorf("error parsing data: %v", err)
+	}
+
+	eventName := target.event.Name
+	if l.Removed {
+		eventName += ".removed"
+	}
+
+	webhookEvent := WebhookRequest{
+		TxHash: l.TxHash.Hex(),
+		Index:  int64(l.Index),
+		Event:  eventName,
+		Data:   currentEvent,
+	}
+
+	webhook := target.webhook
+	var headers map[string]string
+	var payload any = webhookEvent
+	if transformer != nil {
+		skip, override, transformed, err := transformer.Transform(scriptEvent{
+			Event:       eventName,
+			TxHash:      webhookEvent.TxHash,
+			Index:       webhookEvent.Index,
+			BlockNumber: int64(l.BlockNumber),
+			Data:        currentEvent,
+		})
+		if err != nil {
+			return fmt.Errorf("error transforming event: %v", err)
+		}
+		if skip {
+			log.Printf("event at tx %s dropped by script", webhookEvent.TxHash)
+			return nil
+		}
+		if override != nil {
+			webhook = override.URL
+			headers = override.Headers
+			payload = override.Body
+		} else if transformed != nil {
+			payload = transformed
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	rec := EventRecord{
+		Event:       eventName,
+		TxHash:      webhookEvent.TxHash,
+		Index:       webhookEvent.Index,
+		BlockNumber: int64(l.BlockNumber),
+		Removed:     l.Removed,
+		Data:        currentEvent,
+		Address:     l.Address.Hex(),
+		Topic:       l.Topics[0].Hex(),
+	}
+
+	key := fmt.Sprintf("%020d-%s-%d", l.BlockNumber, l.TxHash.Hex(), l.Index)
+	// EventKey/Record travel with the outbox entry so that if the inline
+	// attempt below fails and replayOutbox ends up being the one that
+	// delivers it, the event still gets recorded in store exactly as it
+	// would have on an inline success.
+	entry := outboxEntry{Webhook: webhook, Headers: headers, Body: body, BlockNumber: int64(l.BlockNumber), EventKey: eventKey, Record: &rec}
+	if err := outbox.Put(key, entry); err != nil {
+		return fmt.Errorf("error enqueueing webhook: %v", err)
+	}
+
+	// Only the first delivery attempt happens inline; a failure here is not
+	// an error for handleLog's caller; the outbox entry is already durable,
+	// and replayOutbox's own backoff loop will retry it off the hot path.
+	if err := attemptWebhook(webhook, headers, body, metrics); err != nil {
+		log.Printf("webhook delivery for tx %s failed, left in outbox for retry: %v", webhookEvent.TxHash, err)
+		return nil
+	}
+
+	if err := outbox.Delete(key); err != nil {
+		log.Printf("error removing delivered webhook %s from outbox: %v", key, err)
+	}
+
+	if err := store.RecordEvent(eventKey, rec); err != nil {
+		log.Printf("error recording delivered event %s: %v", eventKey, err)
+	}
+
+	log.Printf("found event at tx %s, with params: %v", webhookEvent.TxHash, webhookEvent)
+	return nil
+}
+
+// sendWebhook attempts delivery with exponential backoff between attempts,
+// giving up after webhookMaxAttempt tries. headers, if non-nil, are merged
+// onto the request in addition to the usual signature headers.
+func sendWebhook(url string, headers map[string]string, body []byte, metrics *Metrics) error {
+	var err error
+	backoff := time.Second
+
+	for attempt := int64(1); attempt <= webhookMaxAttempt; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = attemptWebhook(url, headers, body, metrics); err == nil {
+			return nil
+		}
+		log.Printf("webhook attempt %d/%d to %s failed: %v", attempt, webhookMaxAttempt, url, err)
+	}
+
+	return err
+}
+
+// attemptWebhook performs a single signed POST of body to url.
+func attemptWebhook(url string, headers map[string]string, body []byte, metrics *Metrics) error {
+	httpClient := &http.Client{
+		Timeout: time.Second * time.Duration(webhookTimeout),
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	defer req.Body.Close()
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if webhookSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(webhookSecret))
+		mac.Write([]byte(timestamp))
+		mac.Write(body)
+
+		req.Header.Set("X-Whisper-Signature", hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-Whisper-Timestamp", timestamp)
+	}
+
+	start := time.Now()
+	res, err := httpClient.Do(req)
+	metrics.WebhookLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.WebhookAttempts.WithLabelValues("error").Inc()
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	metrics.WebhookAttempts.WithLabelValues(strconv.Itoa(res.StatusCode)).Inc()
+	if res.StatusCode > 299 || res.StatusCode < 200 {
+		return fmt.Errorf("error in response code %d", res.StatusCode)
+	}
+
 	return nil
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const sampleABI = `[
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[
+		{"name":"from","type":"address","indexed":true},
+		{"name":"to","type":"address","indexed":true},
+		{"name":"value","type":"uint256","indexed":false}
+	]}
+]`
+
+func writeTempFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("error writing %s: %v", path, err)
+	}
+	return path
+}
+
+func TestBuildDispatchTable(t *testing.T) {
+	abiPath := writeTempFile(t, "abi.json", sampleABI)
+	addr := "0x000000000000000000000000000000000000aa"
+
+	cases := []struct {
+		name    string
+		config  WatchConfig
+		wantErr bool
+	}{
+		{
+			name: "single contract single event",
+			config: WatchConfig{Watch: []WatchEntry{
+				{Address: addr, ABI: abiPath, Events: []string{"Transfer"}, Webhook: "http://example.com/hook"},
+			}},
+		},
+		{
+			name: "missing webhook",
+			config: WatchConfig{Watch: []WatchEntry{
+				{Address: addr, ABI: abiPath, Events: []string{"Transfer"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "abi file does not exist",
+			config: WatchConfig{Watch: []WatchEntry{
+				{Address: addr, ABI: filepath.Join(t.TempDir(), "missing.json"), Events: []string{"Transfer"}, Webhook: "http://example.com/hook"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "event not in abi",
+			config: WatchConfig{Watch: []WatchEntry{
+				{Address: addr, ABI: abiPath, Events: []string{"Approval"}, Webhook: "http://example.com/hook"},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			table, addresses, topics, err := buildDispatchTable(c.config)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(addresses) != 1 || len(topics) != 1 {
+				t.Fatalf("expected 1 address and 1 topic, got %d and %d", len(addresses), len(topics))
+			}
+			target, ok := table[common.HexToAddress(addr)][topics[0]]
+			if !ok {
+				t.Fatalf("dispatch table missing entry for %s/%s", addr, topics[0])
+			}
+			if target.webhook != "http://example.com/hook" {
+				t.Fatalf("unexpected webhook: %s", target.webhook)
+			}
+			if len(target.indexed) != 2 {
+				t.Fatalf("expected 2 indexed arguments, got %d", len(target.indexed))
+			}
+		})
+	}
+}
+
+// rpcHeaderServer serves eth_getBlockByNumber requests for the headers in
+// headers, keyed by block number, and nothing else.
+func rpcHeaderServer(t *testing.T, headers map[int64]*types.Header) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []any           `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("error decoding rpc request: %v", err)
+		}
+		if req.Method != "eth_getBlockByNumber" {
+			t.Fatalf("unexpected rpc method: %s", req.Method)
+		}
+
+		number := new(big.Int)
+		number.SetString(req.Params[0].(string)[2:], 16)
+
+		var result any
+		if header, ok := headers[number.Int64()]; ok {
+			result = header
+		}
+
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatalf("error encoding rpc response: %v", err)
+		}
+	}))
+}
+
+func testHeader(number int64, extra byte) *types.Header {
+	return &types.Header{
+		Number:     big.NewInt(number),
+		Difficulty: big.NewInt(1),
+		Extra:      []byte{extra},
+	}
+}
+
+func TestRewindOnReorg(t *testing.T) {
+	h10 := testHeader(10, 1)
+	h11 := testHeader(11, 1)
+	reorgedH11 := testHeader(11, 2)
+	// Shared across subtests: promauto registers collectors on the default
+	// registry, so calling newMetrics() more than once in this process
+	// panics on the duplicate registration.
+	metrics := newMetrics()
+
+	t.Run("no recent hashes leaves starting block untouched", func(t *testing.T) {
+		store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatalf("error opening store: %v", err)
+		}
+		defer store.Close()
+
+		server := rpcHeaderServer(t, nil)
+		defer server.Close()
+		client, err := ethclient.Dial(server.URL)
+		if err != nil {
+			t.Fatalf("error dialing client: %v", err)
+		}
+		defer client.Close()
+
+		outbox, err := openOutbox(filepath.Join(t.TempDir(), "outbox.db"))
+		if err != nil {
+			t.Fatalf("error opening outbox: %v", err)
+		}
+		defer outbox.Close()
+
+		if got := rewindOnReorg(client, store, dispatchTable{}, outbox, metrics, 42); got != 42 {
+			t.Fatalf("expected startingBlock 42 unchanged, got %d", got)
+		}
+	})
+
+	t.Run("matching hashes leave starting block untouched", func(t *testing.T) {
+		store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatalf("error opening store: %v", err)
+		}
+		defer store.Close()
+		store.PutHash(10, h10.Hash())
+		store.PutHash(11, h11.Hash())
+
+		server := rpcHeaderServer(t, map[int64]*types.Header{10: h10, 11: h11})
+		defer server.Close()
+		client, err := ethclient.Dial(server.URL)
+		if err != nil {
+			t.Fatalf("error dialing client: %v", err)
+		}
+		defer client.Close()
+
+		outbox, err := openOutbox(filepath.Join(t.TempDir(), "outbox.db"))
+		if err != nil {
+			t.Fatalf("error opening outbox: %v", err)
+		}
+		defer outbox.Close()
+
+		if got := rewindOnReorg(client, store, dispatchTable{}, outbox, metrics, 12); got != 12 {
+			t.Fatalf("expected startingBlock 12 unchanged, got %d", got)
+		}
+	})
+
+	t.Run("mismatched hash rewinds to the diverged block", func(t *testing.T) {
+		store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatalf("error opening store: %v", err)
+		}
+		defer store.Close()
+		store.PutHash(10, h10.Hash())
+		store.PutHash(11, h11.Hash())
+
+		server := rpcHeaderServer(t, map[int64]*types.Header{10: h10, 11: reorgedH11})
+		defer server.Close()
+		client, err := ethclient.Dial(server.URL)
+		if err != nil {
+			t.Fatalf("error dialing client: %v", err)
+		}
+		defer client.Close()
+
+		outbox, err := openOutbox(filepath.Join(t.TempDir(), "outbox.db"))
+		if err != nil {
+			t.Fatalf("error opening outbox: %v", err)
+		}
+		defer outbox.Close()
+
+		if got := rewindOnReorg(client, store, dispatchTable{}, outbox, metrics, 12); got != 11 {
+			t.Fatalf("expected rewind to block 11, got %d", got)
+		}
+	})
+}
+
+func TestTransformerTransform(t *testing.T) {
+	evt := scriptEvent{Event: "Transfer", TxHash: "0xabc", Index: 1, BlockNumber: 100, Data: map[string]any{"value": "1"}}
+
+	t.Run("dropping the event skips delivery", func(t *testing.T) {
+		script := writeTempFile(t, "handle.js", `function handle(event) { return null; }`)
+		tr, err := newTransformer(script, time.Second)
+		if err != nil {
+			t.Fatalf("error loading script: %v", err)
+		}
+		skip, override, payload, err := tr.Transform(evt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !skip || override != nil || payload != nil {
+			t.Fatalf("expected skip=true and no override/payload, got skip=%v override=%v payload=%v", skip, override, payload)
+		}
+	})
+
+	t.Run("returning an object reshapes the payload", func(t *testing.T) {
+		script := writeTempFile(t, "handle.js", `function handle(event) { return {event: event.event, tx_hash: event.tx_hash}; }`)
+		tr, err := newTransformer(script, time.Second)
+		if err != nil {
+			t.Fatalf("error loading script: %v", err)
+		}
+		skip, override, payload, err := tr.Transform(evt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if skip || override != nil {
+			t.Fatalf("expected skip=false and no override, got skip=%v override=%v", skip, override)
+		}
+		reshaped, ok := payload.(map[string]any)
+		if !ok {
+			t.Fatalf("expected a map payload, got %T", payload)
+		}
+		if reshaped["tx_hash"] != evt.TxHash {
+			t.Fatalf("expected the script to see the snake_case event fields, got %v", reshaped)
+		}
+	})
+
+	t.Run("returning url/headers/body overrides the destination", func(t *testing.T) {
+		script := writeTempFile(t, "handle.js", `function handle(event) {
+			return {url: "http://example.com/other", headers: {"X-Test": "1"}, body: {reshaped: true}};
+		}`)
+		tr, err := newTransformer(script, time.Second)
+		if err != nil {
+			t.Fatalf("error loading script: %v", err)
+		}
+		skip, override, _, err := tr.Transform(evt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if skip || override == nil {
+			t.Fatalf("expected an override, got skip=%v override=%v", skip, override)
+		}
+		if override.URL != "http://example.com/other" || override.Headers["X-Test"] != "1" {
+			t.Fatalf("unexpected override: %+v", override)
+		}
+	})
+}